@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Entry describes a single artefact produced by processFile and retrievable
+// from an Archive.
+type Entry struct {
+	UPI         string
+	Type        string
+	Acquisition time.Time
+	Path        string
+}
+
+// Archive retrieves artefacts produced by processFile for a given time
+// range, UPI and file type (a metadata or sample extension without its
+// dot, such as "xml", "json", "csv" or "parquet", or "ini").
+type Archive interface {
+	Get(ctx context.Context, start, end time.Time, upi, ftype string) (<-chan Entry, error)
+}
+
+// FSArchive is an Archive backed by the on-disk datadir layout produced by
+// processFile. Other backends (e.g. object storage) can implement Archive
+// the same way.
+//
+// metaEnc and sampleEnc must match whatever -fmt processFile was run with:
+// they tell Get which sidecar extension to walk for and how to decode it,
+// and let it derive the sample path from the actual sample extension
+// instead of assuming xml/csv.
+type FSArchive struct {
+	datadir   string
+	metaEnc   MetadataEncoder
+	sampleEnc SampleEncoder
+}
+
+func NewFSArchive(datadir string, metaEnc MetadataEncoder, sampleEnc SampleEncoder) *FSArchive {
+	return &FSArchive{datadir: datadir, metaEnc: metaEnc, sampleEnc: sampleEnc}
+}
+
+func (a *FSArchive) Get(ctx context.Context, start, end time.Time, upi, ftype string) (<-chan Entry, error) {
+	root := a.datadir
+	if upi != "" {
+		if err := validateUPI(upi); err != nil {
+			return nil, err
+		}
+		root = filepath.Join(a.datadir, upi)
+	}
+
+	metaExt := a.metaEnc.Ext()
+	sampleExt := a.sampleEnc.Ext()
+	metaType := strings.TrimPrefix(metaExt, ".")
+	sampleType := strings.TrimPrefix(sampleExt, ".")
+	if ftype == "" {
+		ftype = metaType
+	}
+	if ftype != metaType && ftype != sampleType && ftype != "ini" {
+		return nil, fmt.Errorf("unsupported type %q", ftype)
+	}
+
+	queue := make(chan Entry)
+	go func() {
+		defer close(queue)
+		seen := make(map[string]bool)
+		filepath.Walk(root, func(file string, i os.FileInfo, err error) error {
+			if err != nil || i.IsDir() || filepath.Ext(file) != metaExt {
+				return err
+			}
+
+			r, err := os.Open(file)
+			if err != nil {
+				return nil
+			}
+			side, err := a.metaEnc.Decode(r)
+			r.Close()
+			if err != nil {
+				return nil
+			}
+
+			acqt := side.Acquisition.Time()
+			if acqt.Before(start) || acqt.After(end) {
+				return nil
+			}
+
+			dir := filepath.Dir(filepath.Dir(file))
+			u := filepath.Base(dir)
+
+			var e Entry
+			switch ftype {
+			case metaType:
+				e = Entry{UPI: u, Type: metaType, Acquisition: acqt, Path: file}
+			case sampleType:
+				e = Entry{UPI: u, Type: sampleType, Acquisition: acqt, Path: strings.TrimSuffix(file, metaExt) + sampleExt}
+			case "ini":
+				ini := filepath.Join(dir, u+".ini")
+				if seen[ini] {
+					return nil
+				}
+				seen[ini] = true
+				e = Entry{UPI: u, Type: "ini", Acquisition: acqt, Path: ini}
+			}
+
+			select {
+			case queue <- e:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+	}()
+	return queue, nil
+}
+
+func runServe(args []string) error {
+	set := flag.NewFlagSet("serve", flag.ExitOnError)
+	var (
+		datadir = set.String("d", os.TempDir(), "datadir")
+		addr    = set.String("a", ":8080", "listen address")
+		format  = set.String("fmt", "xml,csv", "metadata,sample output format to expect in datadir (xml,csv|json,csv|json,parquet)")
+	)
+	set.Parse(args)
+
+	metaEnc, sampleEnc, err := parseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	archive := NewFSArchive(*datadir, metaEnc, sampleEnc)
+	http.Handle("/archive", archiveHandler(archive))
+
+	log.Printf("serving %s on %s", *datadir, *addr)
+	return http.ListenAndServe(*addr, nil)
+}
+
+func archiveHandler(a Archive) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		start, err := parseArchiveTime(q.Get("start"), time.Time{})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		end, err := parseArchiveTime(q.Get("end"), time.Now().AddDate(100, 0, 0))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		entries, err := a.Get(r.Context(), start, end, q.Get("upi"), q.Get("type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+		defer mw.Close()
+
+		for e := range entries {
+			f, err := os.Open(e.Path)
+			if err != nil {
+				log.Println(e.Path, err)
+				continue
+			}
+			part, err := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Disposition": {fmt.Sprintf("attachment; filename=%q", filepath.Base(e.Path))},
+				"X-Upi":               {e.UPI},
+				"X-Acquisition-Time":  {e.Acquisition.Format(time.RFC3339Nano)},
+			})
+			if err == nil {
+				io.Copy(part, f)
+			}
+			f.Close()
+		}
+	})
+}
+
+func parseArchiveTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.Parse("200601021504", s)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeDataFile writes a minimal synthetic SVS data segment (Seq != 1,
+// carrying one metadata record and a single-row, single-lag sample table)
+// named name under dir, exercising processMeta/processData rather than the
+// cheaper processIntro path writeIntroFile takes.
+func writeDataFile(t *testing.T, dir, name string, seq uint32, acqt time.Time) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	when := Timestamp(acqt.Sub(GPS))
+	binary.Write(f, binary.BigEndian, [4]byte{'S', 'V', 'S', ' '})
+	binary.Write(f, binary.BigEndian, seq)
+	binary.Write(f, binary.BigEndian, when)
+
+	info := Metadata{Acquisition: when, Sequence: seq, X: 64, Y: 64}
+	binary.Write(f, binary.LittleEndian, info)
+
+	binary.Write(f, binary.LittleEndian, uint8(1))     // one g2(t, N) lag column
+	binary.Write(f, binary.LittleEndian, uint16(1))    // lag N
+	binary.Write(f, binary.LittleEndian, float32(1.5)) // single sample row
+	return path
+}
+
+// TestFSArchiveGetMatchesConfiguredFormat drives processFile with a
+// non-default -fmt and asserts FSArchive.Get, configured with the matching
+// encoders, can find the resulting sidecar and sample, and rejects a type
+// query that doesn't match either configured extension.
+func TestFSArchiveGetMatchesConfiguredFormat(t *testing.T) {
+	indir := t.TempDir()
+	datadir := t.TempDir()
+
+	acqt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	src := writeDataFile(t, indir, "SVS_VIS1_a_b_c_d_e", 2, acqt)
+
+	metaEnc, sampleEnc, err := parseFormat("json,csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := processFile(src, datadir, DefaultFiles, metaEnc, sampleEnc, Compressor{Kind: "none"}, false)
+	if err != nil {
+		t.Fatalf("processFile: %v", err)
+	}
+	if res.Output == "" {
+		t.Fatal("processFile produced no output")
+	}
+
+	archive := NewFSArchive(datadir, metaEnc, sampleEnc)
+
+	entries, err := archive.Get(context.Background(), acqt.Add(-time.Minute), acqt.Add(time.Minute), "", "")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	var got []Entry
+	for e := range entries {
+		got = append(got, e)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d entries for default type, want 1", len(got))
+	}
+	if got[0].Type != "json" {
+		t.Errorf("Type = %q, want %q", got[0].Type, "json")
+	}
+
+	entries, err = archive.Get(context.Background(), time.Time{}, time.Now().AddDate(1, 0, 0), "", "csv")
+	if err != nil {
+		t.Fatalf("Get type=csv: %v", err)
+	}
+	got = nil
+	for e := range entries {
+		got = append(got, e)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d entries for type=csv, want 1", len(got))
+	}
+	if _, err := os.Stat(got[0].Path); err != nil {
+		t.Errorf("sample path %q does not exist: %v", got[0].Path, err)
+	}
+
+	if _, err := archive.Get(context.Background(), time.Time{}, time.Now(), "", "xml"); err == nil {
+		t.Error("Get type=xml against a json,csv archive: want error, got nil")
+	}
+}
+
+// TestArchiveHandlerRejectsUnsupportedType drives archiveHandler itself (not
+// just FSArchive.Get) with an unsupported type query parameter, so an
+// invalid ftype is caught before filepath.Walk starts and reported as a 400
+// rather than a 200 with a truncated multipart body: Get must reject it
+// synchronously, since the error filepath.Walk's callback would otherwise
+// return is produced inside a goroutine, after headers may already be sent.
+func TestArchiveHandlerRejectsUnsupportedType(t *testing.T) {
+	archive := NewFSArchive(t.TempDir(), xmlMetaEncoder{}, csvSampleEncoder{})
+	h := archiveHandler(archive)
+
+	req := httptest.NewRequest("GET", "/archive?type=bogus", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != 400 {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAuditBuffer bounds the Publisher's event channel so a slow
+// subscriber can never stall the ingestion workers.
+const DefaultAuditBuffer = 256
+
+type EventKind string
+
+const (
+	EventProcessed EventKind = "processed"
+	EventSkipped   EventKind = "skipped"
+	EventBad       EventKind = "bad"
+	EventError     EventKind = "error"
+)
+
+// Event records the outcome of a single processFile call for audit sinks.
+type Event struct {
+	Time        time.Time  `json:"time"`
+	Kind        EventKind  `json:"kind"`
+	Source      string     `json:"source"`
+	Output      string     `json:"output,omitempty"`
+	Sequence    uint32     `json:"sequence,omitempty"`
+	Acquisition *time.Time `json:"acquisition,omitempty"`
+	Err         string     `json:"error,omitempty"`
+}
+
+func newEvent(source string, res Result, err error) Event {
+	e := Event{
+		Time:     time.Now(),
+		Source:   source,
+		Output:   res.Output,
+		Sequence: res.Sequence,
+	}
+	if !res.Acquisition.IsZero() {
+		e.Acquisition = &res.Acquisition
+	}
+	switch {
+	case errors.Is(err, ErrUnsafeUPI):
+		e.Kind = EventBad
+	case err != nil:
+		e.Kind = EventError
+		e.Err = err.Error()
+	case res.Output == "":
+		e.Kind = EventSkipped
+	default:
+		e.Kind = EventProcessed
+	}
+	return e
+}
+
+// Sink receives Events published by a Publisher.
+type Sink interface {
+	Publish(Event)
+	Close() error
+}
+
+// Publisher fans Events out to a Sink without ever blocking the ingestion
+// worker that emits them: Publish is non-blocking and drops the event if
+// the bounded channel is full.
+type Publisher struct {
+	events chan Event
+	done   chan struct{}
+}
+
+func NewPublisher(sink Sink, buffer int) *Publisher {
+	p := &Publisher{
+		events: make(chan Event, buffer),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(p.done)
+		defer sink.Close()
+		for e := range p.events {
+			sink.Publish(e)
+		}
+	}()
+	return p
+}
+
+func (p *Publisher) Publish(e Event) {
+	select {
+	case p.events <- e:
+	default:
+	}
+}
+
+func (p *Publisher) Close() {
+	close(p.events)
+	<-p.done
+}
+
+// parseAudit turns the -audit flag value into a Sink: "file:<path>" appends
+// newline-delimited JSON events to path, "unix:<path>" broadcasts them to
+// every subscriber connected to a Unix socket at path, and "" is a no-op.
+func parseAudit(spec string) (Sink, error) {
+	if spec == "" {
+		return noopSink{}, nil
+	}
+	scheme, path, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid -audit value %q, want file:<path> or unix:<path>", spec)
+	}
+	switch scheme {
+	case "file":
+		return newFileSink(path)
+	case "unix":
+		return newUnixSink(path)
+	default:
+		return nil, fmt.Errorf("unsupported audit sink %q", scheme)
+	}
+}
+
+type noopSink struct{}
+
+func (noopSink) Publish(Event) {}
+func (noopSink) Close() error  { return nil }
+
+// fileSink appends newline-delimited JSON events to an append-only file.
+type fileSink struct {
+	mu sync.Mutex
+	w  *os.File
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	w, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{w: w}, nil
+}
+
+func (s *fileSink) Publish(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	json.NewEncoder(s.w).Encode(e)
+}
+
+func (s *fileSink) Close() error {
+	return s.w.Close()
+}
+
+// unixSink broadcasts newline-delimited JSON events to every client
+// connected to a Unix socket, so operators can `cat` it for a live feed.
+type unixSink struct {
+	mu   sync.Mutex
+	ln   net.Listener
+	subs map[net.Conn]struct{}
+}
+
+func newUnixSink(path string) (*unixSink, error) {
+	os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &unixSink{ln: ln, subs: make(map[net.Conn]struct{})}
+	go s.accept()
+	return s, nil
+}
+
+func (s *unixSink) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.subs[conn] = struct{}{}
+		s.mu.Unlock()
+	}
+}
+
+// writeTimeout bounds how long Publish waits on a single subscriber. A
+// stuck subscriber must never hold up the shared sink goroutine, which
+// would back up the Publisher's bounded channel and drop events for every
+// other subscriber too.
+const writeTimeout = 5 * time.Second
+
+func (s *unixSink) Publish(e Event) {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	buf = append(buf, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.subs {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := conn.Write(buf); err != nil {
+			conn.Close()
+			delete(s.subs, conn)
+		}
+	}
+}
+
+func (s *unixSink) Close() error {
+	s.mu.Lock()
+	for conn := range s.subs {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	return s.ln.Close()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNewEventOmitsZeroAcquisition asserts a skipped/error Event, where
+// Result.Acquisition was never set, serializes without an "acquisition"
+// field rather than a fabricated zero-value timestamp.
+func TestNewEventOmitsZeroAcquisition(t *testing.T) {
+	e := newEvent("not-an-svs-file", Result{}, nil)
+	if e.Kind != EventSkipped {
+		t.Fatalf("Kind = %q, want %q", e.Kind, EventSkipped)
+	}
+
+	buf, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(buf), "acquisition") {
+		t.Errorf("zero Acquisition was not omitted: %s", buf)
+	}
+}
+
+func TestNewEventKeepsNonZeroAcquisition(t *testing.T) {
+	acqt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e := newEvent("SVS_VIS1_a_b_c_d_e", Result{Output: "out.xml", Acquisition: acqt}, nil)
+
+	buf, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(buf), acqt.Format(time.RFC3339)) {
+		t.Errorf("non-zero Acquisition missing from output: %s", buf)
+	}
+}
@@ -5,17 +5,19 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
-	"encoding/csv"
+	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"golang.org/x/sync/semaphore"
@@ -31,6 +33,42 @@ const (
 	DefaultFiles   = 512
 )
 
+// ErrUnsafeUPI is returned by validateUPI when a file's UPI segment could
+// escape datadir once joined into an output path.
+var ErrUnsafeUPI = errors.New("chksvs: unsafe upi")
+
+// validateUPI rejects UPI segments that could make the datadir/upi join in
+// processFile resolve outside of datadir, e.g. "..", an absolute path or a
+// NUL byte smuggled in from a maliciously named input file.
+func validateUPI(upi string) error {
+	if upi == "" || strings.HasPrefix(upi, ".") {
+		return ErrUnsafeUPI
+	}
+	if strings.ContainsAny(upi, "/\\\x00") || strings.Contains(upi, "..") {
+		return ErrUnsafeUPI
+	}
+	return nil
+}
+
+// quarantine marks an input file as bad by appending the bad extension, so
+// a later run of chksvs skips it unless invoked with -k.
+func quarantine(file string) error {
+	return os.Rename(file, file+bad)
+}
+
+// rejectUnsafe quarantines file, unless keepbad is set, and reports it as
+// an unsafe UPI. It is used both when a filename doesn't carry enough
+// underscore-delimited parts to contain a UPI and when the UPI itself
+// fails validateUPI.
+func rejectUnsafe(file string, keepbad bool) error {
+	if !keepbad {
+		if err := quarantine(file); err != nil {
+			return err
+		}
+	}
+	return ErrUnsafeUPI
+}
+
 type UPI [32]byte
 
 func (u UPI) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
@@ -39,6 +77,22 @@ func (u UPI) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
 	return e.EncodeElement(string(xs), xml.StartElement{Name: xml.Name{Local: "upi"}})
 }
 
+func (u UPI) MarshalJSON() ([]byte, error) {
+	xs := bytes.Trim(u[:], "\x00")
+	return json.Marshal(string(xs))
+}
+
+func (u *UPI) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	var out UPI
+	copy(out[:], str)
+	*u = out
+	return nil
+}
+
 type Timestamp uint64
 
 func (t Timestamp) String() string {
@@ -49,6 +103,23 @@ func (t Timestamp) Time() time.Time {
 	return GPS.Add(time.Duration(t)).UTC()
 }
 
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	when, err := time.Parse("2006-01-02T15:04:05.999999999Z", str)
+	if err != nil {
+		return err
+	}
+	*t = Timestamp(when.Sub(GPS))
+	return nil
+}
+
 func (t Timestamp) MarshalXMLAttr(n xml.Name) (xml.Attr, error) {
 	a := xml.Attr{
 		Name:  n,
@@ -62,24 +133,57 @@ func (t Timestamp) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
 	return e.EncodeElement(str, xml.StartElement{Name: xml.Name{Local: "acquisition-time"}})
 }
 
+func (t *Timestamp) UnmarshalXMLAttr(a xml.Attr) error {
+	when, err := time.Parse("2006-01-02T15:04:05.999999999Z", a.Value)
+	if err != nil {
+		return err
+	}
+	*t = Timestamp(when.Sub(GPS))
+	return nil
+}
+
+func (t *Timestamp) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var str string
+	if err := d.DecodeElement(&str, &start); err != nil {
+		return err
+	}
+	when, err := time.Parse("2006-01-02T15:04:05.999999999Z", str)
+	if err != nil {
+		return err
+	}
+	*t = Timestamp(when.Sub(GPS))
+	return nil
+}
+
 type Metadata struct {
-	Magic       uint8     `xml:"-"`
-	Acquisition Timestamp `xml:"acquisition-time"`
-	Sequence    uint32    `xml:"originator-seq-no"`
-	Auxiliary   uint64    `xml:"auxiliary-time"`
-	Source      uint8     `xml:"originator-id"`
-	X           uint16    `xml:"source-x-size"`
-	Y           uint16    `xml:"source-y-size"`
-	Format      uint8     `xml:"format"`
-	Drop        uint16    `xml:"fdrp"`
-	OffsetX     uint16    `xml:"roi-x-offset"`
-	SizeX       uint16    `xml:"roi-x-size"`
-	OffsetY     uint16    `xml:"roi-y-offset"`
-	SizeY       uint16    `xml:"roi-y-size"`
-	ScaleX      uint16    `xml:"scale-x-size"`
-	ScaleY      uint16    `xml:"scale-y-size"`
-	Ratio       uint8     `xml:"scale-far"`
-	UPI         UPI       `xml:"user-packet-info"`
+	Magic       uint8     `xml:"-" json:"-"`
+	Acquisition Timestamp `xml:"acquisition-time" json:"acquisition-time"`
+	Sequence    uint32    `xml:"originator-seq-no" json:"originator-seq-no"`
+	Auxiliary   uint64    `xml:"auxiliary-time" json:"auxiliary-time"`
+	Source      uint8     `xml:"originator-id" json:"originator-id"`
+	X           uint16    `xml:"source-x-size" json:"source-x-size"`
+	Y           uint16    `xml:"source-y-size" json:"source-y-size"`
+	Format      uint8     `xml:"format" json:"format"`
+	Drop        uint16    `xml:"fdrp" json:"fdrp"`
+	OffsetX     uint16    `xml:"roi-x-offset" json:"roi-x-offset"`
+	SizeX       uint16    `xml:"roi-x-size" json:"roi-x-size"`
+	OffsetY     uint16    `xml:"roi-y-offset" json:"roi-y-offset"`
+	SizeY       uint16    `xml:"roi-y-size" json:"roi-y-size"`
+	ScaleX      uint16    `xml:"scale-x-size" json:"scale-x-size"`
+	ScaleY      uint16    `xml:"scale-y-size" json:"scale-y-size"`
+	Ratio       uint8     `xml:"scale-far" json:"scale-far"`
+	UPI         UPI       `xml:"user-packet-info" json:"user-packet-info"`
+}
+
+// MetaRecord is the sidecar record written alongside each processed sample
+// file. It mirrors Metadata field-for-field and is shared by every
+// MetadataEncoder implementation so JSON output stays in lockstep with XML.
+type MetaRecord struct {
+	XMLName xml.Name  `xml:"metadata" json:"-"`
+	When    Timestamp `xml:"svs-timestamp,attr" json:"svs-timestamp"`
+	Seq     uint32    `xml:"svs-sequence,attr" json:"svs-sequence"`
+	Source  string    `xml:"svs-file,attr" json:"svs-file"`
+	Metadata
 }
 
 var GPS = time.Date(1980, 1, 6, 0, 0, 0, 0, time.UTC)
@@ -90,13 +194,32 @@ func ini() {
 }
 
 func main() {
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "serve" {
+		if err := runServe(args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	runProcess(args)
+}
+
+func runProcess(args []string) {
+	set := flag.NewFlagSet("chksvs", flag.ExitOnError)
 	var (
-		datadir = flag.String("d", os.TempDir(), "datadir")
-		keepbad = flag.Bool("k", false, "keep-bad")
-		per     = flag.Int64("p", DefaultFiles, "files per directory")
-		workers = flag.Int64("w", DefaultWorkers, "workers")
+		datadir       = set.String("d", os.TempDir(), "datadir")
+		keepbad       = set.Bool("k", false, "keep-bad")
+		per           = set.Int64("p", DefaultFiles, "files per directory")
+		workers       = set.Int64("w", DefaultWorkers, "workers")
+		format        = set.String("fmt", "xml,csv", "metadata,sample output format (xml,csv|json,csv|json,parquet)")
+		watch         = set.Bool("watch", false, "keep watching roots for new files after the initial scan")
+		watchDelay    = set.Duration("watch-delay", 100*time.Millisecond, "debounce before processing a file seen in watch mode")
+		compress      = set.String("compress", "none", "output compression (zstd, gzip or none)")
+		compressLevel = set.Int("compress-level", DefaultCompressLevel, "zstd compression level (1-22)")
+		audit         = set.String("audit", "", "audit event sink (file:<path>, unix:<path>)")
 	)
-	flag.Parse()
+	set.Parse(args)
 
 	if *per <= 0 {
 		*per = DefaultFiles
@@ -105,44 +228,96 @@ func main() {
 		*workers = DefaultWorkers
 	}
 
+	metaEnc, sampleEnc, err := parseFormat(*format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	compressor, err := parseCompressor(*compress, *compressLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	sink, err := parseAudit(*audit)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	pub := NewPublisher(sink, DefaultAuditBuffer)
+	defer pub.Close()
+
 	if err := os.MkdirAll(*datadir, 0755); err != nil && !os.IsExist(err) {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(12)
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	var (
 		fid  int
-		ctx  = context.TODO()
 		sema = semaphore.NewWeighted(*workers)
 	)
-	for f := range iterFiles(flag.Args(), *keepbad) {
+	process := func(f string) bool {
 		if err := sema.Acquire(ctx, 1); err != nil {
-			log.Println(err)
-			os.Exit(1)
+			return false
 		}
 		go func(f string, i int) {
 			defer sema.Release(1)
-			file, err := processFile(f, *datadir, *per)
+			res, err := processFile(f, *datadir, *per, metaEnc, sampleEnc, compressor, *keepbad)
+			pub.Publish(newEvent(f, res, err))
 			if err != nil {
 				log.Println(f, err)
 				return
 			}
-			if file != "" {
-				log.Printf("%6d: processing %s -> %s", i+1, f, file)
+			if res.Output != "" {
+				log.Printf("%6d: processing %s -> %s", i+1, f, res.Output)
 			}
 		}(f, fid)
 		fid++
+		return true
+	}
+
+	for f := range iterFiles(set.Args(), *keepbad) {
+		if !process(f) {
+			break
+		}
 	}
-	if err := sema.Acquire(ctx, *workers); err != nil {
+
+	if *watch {
+		queue, err := watchFiles(ctx, set.Args(), *keepbad, *watchDelay)
+		if err != nil {
+			log.Println(err)
+		} else {
+			for f := range queue {
+				if !process(f) {
+					break
+				}
+			}
+		}
+	}
+
+	if err := sema.Acquire(context.Background(), *workers); err != nil {
 		log.Println(err)
 		os.Exit(1)
 	}
 }
 
-func processFile(file, datadir string, per int64) (string, error) {
+// Result describes the outcome of a processFile call, for callers that want
+// to report it (see Event). It is returned alongside an error too, carrying
+// whatever processFile had read from the file by then.
+type Result struct {
+	Output      string
+	Sequence    uint32
+	Acquisition time.Time
+}
+
+func processFile(file, datadir string, per int64, metaEnc MetadataEncoder, sampleEnc SampleEncoder, compressor Compressor, keepbad bool) (Result, error) {
 	r, err := os.Open(file)
 	if err != nil {
-		return "", err
+		return Result{}, err
 	}
 	defer r.Close()
 
@@ -152,52 +327,62 @@ func processFile(file, datadir string, per int64) (string, error) {
 		When Timestamp
 	}{}
 	if err := binary.Read(r, binary.BigEndian, &meta); err != nil {
-		return "", err
+		return Result{}, err
 	}
 	if !bytes.Equal(meta.FCC[:], []byte(magic)) {
-		return "", nil
+		return Result{}, nil
 	}
 
-	var (
-		parts = strings.Split(filepath.Base(file), "_")
-		upi   = strings.Join(parts[1:len(parts)-5], "_")
-	)
+	res := Result{Sequence: meta.Seq, Acquisition: meta.When.Time()}
+
+	parts := strings.Split(filepath.Base(file), "_")
+	if len(parts) < 6 {
+		return res, rejectUnsafe(file, keepbad)
+	}
+	upi := strings.Join(parts[1:len(parts)-5], "_")
+	if err := validateUPI(upi); err != nil {
+		return res, rejectUnsafe(file, keepbad)
+	}
 	datadir = filepath.Join(datadir, upi)
 
 	if err := os.MkdirAll(datadir, 0755); err != nil {
-		return "", err
+		return res, err
 	}
 
 	if meta.Seq == 1 {
-		return processIntro(r, datadir, upi)
+		res.Output, err = processIntro(r, datadir, upi, compressor)
+		return res, err
 	}
-	file, per, err = processMeta(r, datadir, upi, file, per, meta.Seq, meta.When)
+	var outfile string
+	outfile, per, err = processMeta(r, datadir, upi, file, per, meta.Seq, meta.When, metaEnc, sampleEnc, compressor)
 	if err == nil {
-		file = filepath.Join(datadir, fmt.Sprintf("%06d", per), file)
-		err = processData(r, file)
+		outfile = filepath.Join(datadir, fmt.Sprintf("%06d", per), outfile)
+		outfile, err = processData(r, outfile, sampleEnc, compressor)
 	}
-	return file, err
+	res.Output = outfile
+	return res, err
 }
 
-func processIntro(r io.Reader, datadir, upi string) (string, error) {
-	w, err := os.Create(filepath.Join(datadir, upi+".ini"))
+func processIntro(r io.Reader, datadir, upi string, compressor Compressor) (string, error) {
+	w, name, err := compressor.openOutput(filepath.Join(datadir, upi+".ini"))
 	if err != nil {
 		return "", err
 	}
 	defer w.Close()
 
 	_, err = io.Copy(w, r)
-	return w.Name(), err
+	return name, err
 }
 
-func processMeta(r io.Reader, datadir, upi, source string, per int64, seq uint32, when Timestamp) (string, int64, error) {
+func processMeta(r io.Reader, datadir, upi, source string, per int64, seq uint32, when Timestamp, metaEnc MetadataEncoder, sampleEnc SampleEncoder, compressor Compressor) (string, int64, error) {
 	var info Metadata
 	if err := binary.Read(r, binary.LittleEndian, &info); err != nil {
 		return "", -1, err
 	}
 	var (
 		acqt   = info.Acquisition.Time()
-		file   = fmt.Sprintf("%04x_%s_%s_%06d.csv", info.Source, upi, acqt.Format("20060102_150406"), info.Sequence)
+		base   = fmt.Sprintf("%04x_%s_%s_%06d", info.Source, upi, acqt.Format("20060102_150406"), info.Sequence)
+		sample = base + sampleEnc.Ext()
 		subdir = int64(info.Sequence) / per
 	)
 
@@ -206,74 +391,59 @@ func processMeta(r io.Reader, datadir, upi, source string, per int64, seq uint32
 		return "", subdir, err
 	}
 
-	w, err := os.Create(filepath.Join(datadir, file) + ".xml")
+	w, _, err := compressor.openOutput(filepath.Join(datadir, base+metaEnc.Ext()))
 	if err != nil {
 		return "", subdir, err
 	}
 	defer w.Close()
 
-	elem := struct {
-		XMLName xml.Name  `xml:"metadata"`
-		When    Timestamp `xml:"svs-timestamp,attr"`
-		Seq     uint32    `xml:"svs-sequence,attr"`
-		Source  string    `xml:"svs-file,attr"`
-		Metadata
-	}{
+	rec := MetaRecord{
 		When:     when,
 		Seq:      seq,
 		Source:   filepath.Base(source),
 		Metadata: info,
 	}
-
-	e := xml.NewEncoder(w)
-	e.Indent("", "\t")
-	return file, subdir, e.Encode(elem)
+	return sample, subdir, metaEnc.Encode(w, rec)
 }
 
-func processData(r io.Reader, file string) error {
-	w, err := os.Create(file)
+func processData(r io.Reader, file string, enc SampleEncoder, compressor Compressor) (string, error) {
+	w, name, err := compressor.openOutput(file)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer w.Close()
 
 	buf, err := ioutil.ReadAll(r)
 	if err != nil {
-		return err
+		return "", err
 	}
-	var (
-		ws = csv.NewWriter(w)
-		rs = bytes.NewReader(buf)
-	)
+	rs := bytes.NewReader(buf)
 
 	b, err := rs.ReadByte()
 	if err != nil {
-		return err
+		return "", err
 	}
-	vs := make([]string, int(b)+1)
-	vs[0] = "t"
+	sample := Sample{Columns: make([]string, int(b)+1)}
+	sample.Columns[0] = "t"
 	for j := 0; j < int(b); j++ {
 		var v uint16
 		if err := binary.Read(rs, binary.LittleEndian, &v); err != nil {
-			return err
+			return "", err
 		}
-		vs[j+1] = fmt.Sprintf("g2(t, %d)", v)
+		sample.Columns[j+1] = fmt.Sprintf("g2(t, %d)", v)
 	}
-	ws.Write(vs)
 	for i := 0; rs.Len() > 0; i++ {
-		vs[0] = strconv.Itoa(i)
-		for j := 0; j < int(b); j++ {
-			var v float32
-			if err := binary.Read(rs, binary.LittleEndian, &v); err != nil {
-				return err
+		row := make([]float32, int(b))
+		for j := range row {
+			if err := binary.Read(rs, binary.LittleEndian, &row[j]); err != nil {
+				return "", err
 			}
-			vs[j+1] = strconv.FormatFloat(float64(v), 'f', -1, 32)
 		}
-		ws.Write(vs)
+		sample.T = append(sample.T, i)
+		sample.Values = append(sample.Values, row)
 	}
 
-	ws.Flush()
-	return ws.Error()
+	return name, enc.Encode(w, sample)
 }
 
 func iterFiles(files []string, keepbad bool) <-chan string {
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeIntroFile writes a minimal synthetic SVS "intro" segment (Seq == 1)
+// named name under dir, so processFile takes the cheapest path through the
+// UPI-derived datadir join.
+func writeIntroFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	binary.Write(f, binary.BigEndian, [4]byte{'S', 'V', 'S', ' '})
+	binary.Write(f, binary.BigEndian, uint32(1))
+	binary.Write(f, binary.BigEndian, uint64(0))
+	return path
+}
+
+func TestValidateUPI(t *testing.T) {
+	cases := []struct {
+		upi string
+		ok  bool
+	}{
+		{"VIS1", true},
+		{"VIS_CAM-1", true},
+		{"", false},
+		{"..", false},
+		{"../../etc", false},
+		{".hidden", false},
+		{"a/b", false},
+		{"a\\b", false},
+		{"a\x00b", false},
+	}
+	for _, c := range cases {
+		err := validateUPI(c.upi)
+		if c.ok && err != nil {
+			t.Errorf("validateUPI(%q): unexpected error %v", c.upi, err)
+		}
+		if !c.ok && !errors.Is(err, ErrUnsafeUPI) {
+			t.Errorf("validateUPI(%q): want ErrUnsafeUPI, got %v", c.upi, err)
+		}
+	}
+}
+
+// TestProcessFileStaysInDatadir drives processFile with both a safe and a
+// collection of maliciously named input files, and asserts that whenever it
+// reports an output path, that path resolves under datadir once cleaned -
+// never outside of it, regardless of the input filename.
+func TestProcessFileStaysInDatadir(t *testing.T) {
+	names := []string{
+		"SVS_VIS1_a_b_c_d_e",
+		"SVS_VIS_CAM1_a_b_c_d_e",
+		"SVS_.._a_b_c_d_e",
+		"SVS_.hidden_a_b_c_d_e",
+		"tooshort",
+	}
+
+	for _, name := range names {
+		indir := t.TempDir()
+		datadir := t.TempDir()
+		src := writeIntroFile(t, indir, name)
+
+		res, err := processFile(src, datadir, DefaultFiles, xmlMetaEncoder{}, csvSampleEncoder{}, Compressor{Kind: "none"}, true)
+		if err != nil {
+			continue
+		}
+		if res.Output == "" {
+			continue
+		}
+		clean := filepath.Clean(res.Output)
+		rel, rerr := filepath.Rel(datadir, clean)
+		if rerr != nil || strings.HasPrefix(rel, "..") {
+			t.Errorf("name %q: output %q escaped datadir %q", name, res.Output, datadir)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/DataDog/zstd"
+)
+
+// DefaultCompressLevel is the zstd level used when -compress-level is left
+// at its zero value.
+const DefaultCompressLevel = 3
+
+// Compressor selects how processIntro, processMeta and processData wrap the
+// writers they create for the XML/JSON sidecars and the sample table.
+type Compressor struct {
+	Kind  string // "zstd", "gzip" or "none"
+	Level int    // zstd level, 1-22; unused for gzip
+}
+
+func parseCompressor(kind string, level int) (Compressor, error) {
+	switch kind {
+	case "", "none", "zstd", "gzip":
+	default:
+		return Compressor{}, fmt.Errorf("unsupported compression %q", kind)
+	}
+	if level <= 0 {
+		level = DefaultCompressLevel
+	}
+	return Compressor{Kind: kind, Level: level}, nil
+}
+
+// openOutput creates path, appending .zst or .gz when compression is
+// enabled, and returns a writer that applies the configured compression
+// along with the path actually created on disk.
+func (c Compressor) openOutput(path string) (io.WriteCloser, string, error) {
+	switch c.Kind {
+	case "zstd":
+		path += ".zst"
+	case "gzip":
+		path += ".gz"
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch c.Kind {
+	case "zstd":
+		return &compressedFile{WriteCloser: zstd.NewWriterLevel(f, c.Level), file: f}, path, nil
+	case "gzip":
+		return &compressedFile{WriteCloser: gzip.NewWriter(f), file: f}, path, nil
+	default:
+		return f, path, nil
+	}
+}
+
+// compressedFile closes the compressor before the underlying file, since
+// neither gzip.Writer nor zstd.Writer close the writer they wrap.
+type compressedFile struct {
+	io.WriteCloser
+	file *os.File
+}
+
+func (c *compressedFile) Close() error {
+	if err := c.WriteCloser.Close(); err != nil {
+		c.file.Close()
+		return err
+	}
+	return c.file.Close()
+}
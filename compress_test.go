@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DataDog/zstd"
+)
+
+// representativeSample builds a g2(t, N) sample table shaped like a
+// real SVS run: several lag columns, several thousand rows, so the
+// zstd/gzip round trip and benchmark exercise more than a handful of bytes.
+func representativeSample(tb testing.TB) []byte {
+	tb.Helper()
+	s := Sample{Columns: []string{"t", "g2(t, 1)", "g2(t, 2)", "g2(t, 4)", "g2(t, 8)"}}
+	for i := 0; i < 4096; i++ {
+		s.T = append(s.T, i)
+		s.Values = append(s.Values, []float32{
+			1 + 0.01*float32(i%97),
+			1 + 0.02*float32(i%53),
+			1 + 0.03*float32(i%29),
+			1 + 0.04*float32(i%11),
+		})
+	}
+	var buf bytes.Buffer
+	if err := (csvSampleEncoder{}).Encode(&buf, s); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCompressorRoundTrip(t *testing.T) {
+	data := representativeSample(t)
+
+	for _, kind := range []string{"zstd", "gzip", "none"} {
+		t.Run(kind, func(t *testing.T) {
+			c, err := parseCompressor(kind, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dir := t.TempDir()
+			w, path, err := c.openOutput(filepath.Join(dir, "sample.csv"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []byte
+			switch kind {
+			case "zstd":
+				r := zstd.NewReader(bytes.NewReader(raw))
+				got, err = io.ReadAll(r)
+				r.Close()
+			case "gzip":
+				var gr *gzip.Reader
+				gr, err = gzip.NewReader(bytes.NewReader(raw))
+				if err == nil {
+					got, err = io.ReadAll(gr)
+					gr.Close()
+				}
+			default:
+				got = raw
+			}
+			if err != nil {
+				t.Fatalf("decompress: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("round trip mismatch: got %d bytes, want %d bytes", len(got), len(data))
+			}
+		})
+	}
+}
+
+// failingWriteCloser always fails to Close, so compressedFile.Close's
+// double-close-on-error path can be exercised without a real codec.
+type failingWriteCloser struct{}
+
+func (failingWriteCloser) Write(p []byte) (int, error) { return len(p), nil }
+func (failingWriteCloser) Close() error                { return errors.New("boom") }
+
+func TestCompressedFileCloseClosesFileOnWriterError(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cf := &compressedFile{WriteCloser: failingWriteCloser{}, file: f}
+	if err := cf.Close(); err == nil {
+		t.Fatal("Close: want error from the failing writer, got nil")
+	}
+
+	if err := f.Close(); err == nil {
+		t.Error("underlying file was not closed when the writer's Close failed")
+	}
+}
+
+// BenchmarkZstdVsRaw reports the raw and zstd-compressed sizes of a
+// representative SVS sample table, so the compression ratio zstd reaches at
+// DefaultCompressLevel is visible in `go test -bench`.
+func BenchmarkZstdVsRaw(b *testing.B) {
+	data := representativeSample(b)
+	dir := b.TempDir()
+
+	c, err := parseCompressor("zstd", DefaultCompressLevel)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var compressed int64
+	for i := 0; i < b.N; i++ {
+		path := filepath.Join(dir, "bench.csv")
+		w, outpath, err := c.openOutput(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			b.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			b.Fatal(err)
+		}
+		info, err := os.Stat(outpath)
+		if err != nil {
+			b.Fatal(err)
+		}
+		compressed = info.Size()
+		os.Remove(outpath)
+	}
+
+	b.ReportMetric(float64(len(data)), "raw-bytes")
+	b.ReportMetric(float64(compressed), "zstd-bytes")
+	b.ReportMetric(float64(len(data))/float64(compressed), "ratio")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/segmentio/parquet-go"
+)
+
+// Sample is the decoded g2(t, N) sample table produced by an SVS data
+// segment, ready to be handed to a SampleEncoder.
+type Sample struct {
+	Columns []string // "t" followed by one "g2(t, N)" column per lag
+	T       []int
+	Values  [][]float32
+}
+
+// MetadataEncoder writes the sidecar record for a processed sample file and
+// reads it back, so FSArchive can recognize whichever format -fmt selected
+// without hard-coding an extension.
+type MetadataEncoder interface {
+	Encode(w io.Writer, rec MetaRecord) error
+	Decode(r io.Reader) (MetaRecord, error)
+	Ext() string
+}
+
+// SampleEncoder writes the g2(t, N) sample table for a processed data
+// segment.
+type SampleEncoder interface {
+	Encode(w io.Writer, s Sample) error
+	Ext() string
+}
+
+// parseFormat turns a "-fmt" flag value such as "xml,csv", "json,csv" or
+// "json,parquet" into the matching encoder pair. An empty string selects
+// the historical xml,csv pair.
+func parseFormat(format string) (MetadataEncoder, SampleEncoder, error) {
+	switch format {
+	case "", "xml,csv":
+		return xmlMetaEncoder{}, csvSampleEncoder{}, nil
+	case "json,csv":
+		return jsonMetaEncoder{}, csvSampleEncoder{}, nil
+	case "json,parquet":
+		return jsonMetaEncoder{}, parquetSampleEncoder{}, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+type xmlMetaEncoder struct{}
+
+func (xmlMetaEncoder) Ext() string { return ".xml" }
+
+func (xmlMetaEncoder) Encode(w io.Writer, rec MetaRecord) error {
+	e := xml.NewEncoder(w)
+	e.Indent("", "\t")
+	return e.Encode(rec)
+}
+
+func (xmlMetaEncoder) Decode(r io.Reader) (MetaRecord, error) {
+	var rec MetaRecord
+	err := xml.NewDecoder(r).Decode(&rec)
+	return rec, err
+}
+
+type jsonMetaEncoder struct{}
+
+func (jsonMetaEncoder) Ext() string { return ".json" }
+
+func (jsonMetaEncoder) Encode(w io.Writer, rec MetaRecord) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	return e.Encode(rec)
+}
+
+func (jsonMetaEncoder) Decode(r io.Reader) (MetaRecord, error) {
+	var rec MetaRecord
+	err := json.NewDecoder(r).Decode(&rec)
+	return rec, err
+}
+
+type csvSampleEncoder struct{}
+
+func (csvSampleEncoder) Ext() string { return ".csv" }
+
+func (csvSampleEncoder) Encode(w io.Writer, s Sample) error {
+	ws := csv.NewWriter(w)
+	ws.Write(s.Columns)
+
+	row := make([]string, len(s.Columns))
+	for i, vs := range s.Values {
+		row[0] = strconv.Itoa(s.T[i])
+		for j, v := range vs {
+			row[j+1] = strconv.FormatFloat(float64(v), 'f', -1, 32)
+		}
+		ws.Write(row)
+	}
+
+	ws.Flush()
+	return ws.Error()
+}
+
+// parquetSampleEncoder writes the sample table as a single-row-group
+// Parquet file, one float32 column per g2(t, N) lag plus the "t" index, so
+// long SVS runs can be queried column-wise instead of re-parsing CSV.
+//
+// The column set (and therefore the schema) varies per file, so rows are
+// built as a map[string]float32 rather than a fixed Go struct. Every column,
+// including "t", is declared as FloatType so the map stays a single,
+// concretely-typed Go type end to end: parquet-go's Group schema resolves a
+// field's Go value with a plain map index, and a map[string]interface{}
+// loses the underlying Kind by the time it gets there, which is what made
+// the previous version panic on every write.
+type parquetSampleEncoder struct{}
+
+func (parquetSampleEncoder) Ext() string { return ".parquet" }
+
+func (parquetSampleEncoder) Encode(w io.Writer, s Sample) error {
+	group := make(parquet.Group, len(s.Columns))
+	for _, c := range s.Columns {
+		group[c] = parquet.Leaf(parquet.FloatType)
+	}
+	schema := parquet.NewSchema("sample", group)
+
+	pw := parquet.NewWriter(w, schema)
+	rec := make(map[string]float32, len(s.Columns))
+	for i, vs := range s.Values {
+		rec[s.Columns[0]] = float32(s.T[i])
+		for j, v := range vs {
+			rec[s.Columns[j+1]] = v
+		}
+		if err := pw.Write(rec); err != nil {
+			pw.Close()
+			return err
+		}
+	}
+	return pw.Close()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+
+	"github.com/segmentio/parquet-go"
+)
+
+// syntheticMetaRecord returns the sidecar record for a synthetic SVS file,
+// shared by every MetadataEncoder round-trip test so XML and JSON cover the
+// same fixture.
+func syntheticMetaRecord() MetaRecord {
+	var upi UPI
+	copy(upi[:], "VIS1")
+	return MetaRecord{
+		When:   Timestamp(0),
+		Seq:    42,
+		Source: "SVS_VIS1_a_b_c_d_e",
+		Metadata: Metadata{
+			Acquisition: Timestamp(0),
+			Sequence:    42,
+			Source:      3,
+			X:           1024,
+			Y:           768,
+			UPI:         upi,
+		},
+	}
+}
+
+func TestXMLMetaEncoderRoundTrip(t *testing.T) {
+	rec := syntheticMetaRecord()
+
+	var buf bytes.Buffer
+	if err := (xmlMetaEncoder{}).Encode(&buf, rec); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	var got MetaRecord
+	if err := xml.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got.Seq != rec.Seq || got.Source != rec.Source || got.X != rec.X || got.Y != rec.Y {
+		t.Errorf("round trip mismatch: got %+v, want %+v", got, rec)
+	}
+}
+
+func TestJSONMetaEncoderRoundTrip(t *testing.T) {
+	rec := syntheticMetaRecord()
+
+	var buf bytes.Buffer
+	if err := (jsonMetaEncoder{}).Encode(&buf, rec); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	// Decode into a generic map rather than MetaRecord itself, so the check
+	// sees the field names and values exactly as they were written.
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got["svs-sequence"].(float64) != float64(rec.Seq) || got["svs-file"].(string) != rec.Source {
+		t.Errorf("round trip mismatch: got %+v, want %+v", got, rec)
+	}
+	if got["user-packet-info"].(string) != "VIS1" {
+		t.Errorf("upi round trip: got %v, want VIS1", got["user-packet-info"])
+	}
+}
+
+// syntheticSample returns the g2(t, N) sample table for a synthetic SVS
+// file, shared by every SampleEncoder round-trip test.
+func syntheticSample() Sample {
+	return Sample{
+		Columns: []string{"t", "g2(t, 1)", "g2(t, 2)"},
+		T:       []int{0, 1, 2},
+		Values: [][]float32{
+			{1.0, 1.1},
+			{2.0, 2.1},
+			{3.0, 3.1},
+		},
+	}
+}
+
+func TestCSVSampleEncoderRoundTrip(t *testing.T) {
+	s := syntheticSample()
+
+	var buf bytes.Buffer
+	if err := (csvSampleEncoder{}).Encode(&buf, s); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	r := csv.NewReader(&buf)
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if len(records) != len(s.T)+1 {
+		t.Fatalf("got %d records, want %d", len(records), len(s.T)+1)
+	}
+	if !equalStrings(records[0], s.Columns) {
+		t.Errorf("header: got %v, want %v", records[0], s.Columns)
+	}
+}
+
+func TestParquetSampleEncoderRoundTrip(t *testing.T) {
+	s := syntheticSample()
+
+	var buf bytes.Buffer
+	if err := (parquetSampleEncoder{}).Encode(&buf, s); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	pr := parquet.NewReader(bytes.NewReader(buf.Bytes()))
+	defer pr.Close()
+
+	for i := range s.T {
+		rec := make(map[string]float32)
+		if err := pr.Read(&rec); err != nil {
+			t.Fatalf("Read row %d: %v", i, err)
+		}
+		if got := rec["t"]; got != float32(s.T[i]) {
+			t.Errorf("row %d: t = %v, want %v", i, got, s.T[i])
+		}
+		for j, v := range s.Values[i] {
+			if got := rec[s.Columns[j+1]]; got != v {
+				t.Errorf("row %d: %s = %v, want %v", i, s.Columns[j+1], got, v)
+			}
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
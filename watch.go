@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchFiles watches each of roots recursively for newly written SVS files
+// and pushes their paths onto the returned channel, so chksvs can run as a
+// long-lived ingestion daemon instead of a batch job. A file still being
+// written triggers several fsnotify events before it is complete, so events
+// on the same path are debounced by delay before the file is handed off.
+// The channel closes once ctx is cancelled.
+func watchFiles(ctx context.Context, roots []string, keepbad bool, delay time.Duration) (<-chan string, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range roots {
+		err := filepath.Walk(root, func(p string, i os.FileInfo, err error) error {
+			if err != nil || !i.IsDir() {
+				return err
+			}
+			return w.Add(p)
+		})
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+
+	queue := make(chan string)
+	go func() {
+		defer close(queue)
+		defer w.Close()
+
+		pending := make(map[string]*time.Timer)
+		fire := make(chan string)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if ev.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+					continue
+				}
+				if i, err := os.Stat(ev.Name); err == nil && i.IsDir() {
+					w.Add(ev.Name)
+					continue
+				}
+				if filepath.Ext(ev.Name) == bad && !keepbad {
+					continue
+				}
+				name := ev.Name
+				if t, ok := pending[name]; ok {
+					t.Reset(delay)
+					continue
+				}
+				pending[name] = time.AfterFunc(delay, func() {
+					select {
+					case fire <- name:
+					case <-ctx.Done():
+					}
+				})
+			case name := <-fire:
+				delete(pending, name)
+				if !looksLikeSVS(name) {
+					continue
+				}
+				select {
+				case queue <- name:
+				case <-ctx.Done():
+					return
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				log.Println(err)
+			}
+		}
+	}()
+	return queue, nil
+}
+
+func looksLikeSVS(file string) bool {
+	r, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+
+	buf := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return false
+	}
+	return string(buf) == magic
+}